@@ -0,0 +1,30 @@
+// Package passive registers the subscraping.Source implementations that
+// subfinder queries during passive enumeration.
+package passive
+
+import (
+	"strings"
+
+	"github.com/projectdiscovery/subfinder/v2/pkg/subscraping"
+	"github.com/projectdiscovery/subfinder/v2/pkg/subscraping/sources/quake"
+)
+
+// AllSources is the list of passive sources subfinder queries. Quake is
+// registered three times under its logical sub-source names so users can
+// choose which cost center to spend query credits on: "quake" searches by
+// domain, "quake-cert" by certificate content, and "quake-dns" by hostname.
+var AllSources = []subscraping.Source{
+	&quake.Source{},
+	&quake.Source{Mode: quake.CertMode},
+	&quake.Source{Mode: quake.HostnameMode},
+}
+
+// NameSourceMap indexes AllSources by their lowercased Name() so callers can
+// resolve a user-selected source list.
+var NameSourceMap = make(map[string]subscraping.Source, len(AllSources))
+
+func init() {
+	for _, source := range AllSources {
+		NameSourceMap[strings.ToLower(source.Name())] = source
+	}
+}
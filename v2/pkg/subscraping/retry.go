@@ -0,0 +1,54 @@
+package subscraping
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// RetryableError wraps an error that is transient (network hiccup, rate
+// limit, malformed response under load) as opposed to fatal (bad
+// credentials, exhausted quota). Sources should wrap errors they want
+// RetryWithBackoff to retry in a *RetryableError; any other error aborts
+// the retry loop immediately.
+type RetryableError struct {
+	Err error
+}
+
+func (e *RetryableError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryableError) Unwrap() error {
+	return e.Err
+}
+
+// RetryWithBackoff calls fn until it succeeds, returns a fatal (non
+// *RetryableError) error, ctx is cancelled, or maxAttempts is reached,
+// whichever happens first. Between attempts it waits a jittered
+// exponentially increasing delay starting at base. It returns the number
+// of attempts made, so callers can surface it in their Statistics.
+func RetryWithBackoff(ctx context.Context, maxAttempts int, base time.Duration, fn func(attempt int) error) (attempts int, err error) {
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		attempts++
+
+		err = fn(attempt)
+		if err == nil {
+			return attempts, nil
+		}
+
+		var retryable *RetryableError
+		if !errors.As(err, &retryable) || attempt == maxAttempts-1 {
+			return attempts, err
+		}
+
+		delay := base*time.Duration(1<<uint(attempt)) + time.Duration(rand.Int63n(int64(base)))
+		select {
+		case <-ctx.Done():
+			return attempts, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+	return attempts, err
+}
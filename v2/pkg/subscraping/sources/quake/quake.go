@@ -6,6 +6,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/projectdiscovery/gologger"
+	"regexp"
 	"strings"
 	"time"
 
@@ -14,23 +15,152 @@ import (
 	"github.com/projectdiscovery/subfinder/v2/pkg/subscraping"
 )
 
-type quakeResults struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
-	Data    []struct {
-		Service struct {
-			HTTP struct {
-				Host string `json:"host"`
-			} `json:"http"`
+// defaultRateLimit is the delay observed between paginated requests,
+// matching Quake's free-tier QPS cap.
+const defaultRateLimit = 3 * time.Second
+
+// maxPageRetries bounds the number of attempts RetryWithBackoff makes for
+// a single page before the error is surfaced as fatal.
+const maxPageRetries = 3
+
+// retryBaseDelay is the starting delay used by RetryWithBackoff; it doubles
+// (plus jitter) on each subsequent attempt.
+const retryBaseDelay = 2 * time.Second
+
+// fatalQuakeCodes are Quake error codes that will never succeed on retry -
+// q2001 (authentication failure) and q3005 (quota exhausted).
+var fatalQuakeCodes = []string{"q2001", "q3005"}
+
+// QueryMode selects which Quake search DSL clause a Source issues, letting
+// users pick which cost center to spend query credits on. The zero value,
+// DomainMode, reproduces the original `domain: <target>` search.
+type QueryMode int
+
+const (
+	// DomainMode searches `domain: <target>`, Quake's plain domain search.
+	DomainMode QueryMode = iota
+	// CertMode searches `cert: "<target>"`, matching records whose TLS
+	// certificate mentions the domain - catches hosts on shared
+	// infrastructure that a domain search misses.
+	CertMode
+	// HostnameMode searches `hostname: "*.<target>"` against Quake's
+	// hostname/DNS record index directly.
+	HostnameMode
+)
+
+// name is the logical sub-source name exposed for this mode, e.g. users can
+// select "quake-cert" to spend credits only on certificate-content search.
+func (m QueryMode) name() string {
+	switch m {
+	case CertMode:
+		return "quake-cert"
+	case HostnameMode:
+		return "quake-dns"
+	default:
+		return "quake"
+	}
+}
+
+// queryTemplate is the Quake DSL clause for this mode, with %s standing in
+// for the target domain.
+func (m QueryMode) queryTemplate() string {
+	switch m {
+	case CertMode:
+		return `cert: "%s"`
+	case HostnameMode:
+		return `hostname: "*.%s"`
+	default:
+		return `domain: %s`
+	}
+}
+
+func isFatalQuakeMessage(message string) bool {
+	for _, code := range fatalQuakeCodes {
+		if strings.Contains(message, code) {
+			return true
 		}
-	} `json:"data"`
-	Meta struct {
+	}
+	return false
+}
+
+// quakeRequest is the body of a Quake search request. It is marshalled
+// with jsoniter rather than string-formatted into a raw JSON literal
+// so that query - which may contain quotes, e.g. `cert: "example.com"` -
+// is escaped correctly.
+type quakeRequest struct {
+	Query       string   `json:"query"`
+	Start       int      `json:"start"`
+	Size        int      `json:"size"`
+	IgnoreCache bool     `json:"ignore_cache"`
+	Include     []string `json:"include"`
+}
+
+type quakeResults struct {
+	Code    int           `json:"code"`
+	Message string        `json:"message"`
+	Data    []quakeRecord `json:"data"`
+	Meta    struct {
 		Pagination struct {
 			Total int `json:"total"`
 		} `json:"pagination"`
 	} `json:"meta"`
 }
 
+type quakeRecord struct {
+	Domain   string `json:"domain"`
+	Hostname string `json:"hostname"`
+	Service  struct {
+		HTTP struct {
+			Host string `json:"host"`
+		} `json:"http"`
+		TLS struct {
+			Handshake struct {
+				Cert struct {
+					Subject struct {
+						CN string `json:"cn"`
+					} `json:"subject"`
+					Extensions struct {
+						SubjectAltName struct {
+							DNSNames []string `json:"dns_names"`
+						} `json:"subject_alt_name"`
+					} `json:"extensions"`
+				} `json:"cert"`
+			} `json:"handshake"`
+		} `json:"tls"`
+	} `json:"service"`
+}
+
+// subdomains returns every candidate subdomain present in the record - the
+// HTTP host, the DNS names and common name from the TLS certificate, and
+// the record's own domain/hostname fields - deduped and filtered down to
+// the ones that actually belong to target. Quake returns unrelated SAN
+// entries for hosts sharing a certificate, so the filter matters as much
+// as the extra fields do.
+func (r quakeRecord) subdomains(extractor *regexp.Regexp) []string {
+	candidates := append([]string{
+		r.Service.HTTP.Host,
+		r.Service.TLS.Handshake.Cert.Subject.CN,
+		r.Domain,
+		r.Hostname,
+	}, r.Service.TLS.Handshake.Cert.Extensions.SubjectAltName.DNSNames...)
+
+	seen := make(map[string]struct{}, len(candidates))
+	var subdomains []string
+	for _, candidate := range candidates {
+		if candidate == "" || strings.ContainsAny(candidate, "暂无权限") {
+			continue
+		}
+		for _, subdomain := range extractor.FindAllString(candidate, -1) {
+			if _, ok := seen[subdomain]; ok {
+				continue
+			}
+			seen[subdomain] = struct{}{}
+			subdomains = append(subdomains, subdomain)
+		}
+	}
+	return subdomains
+}
+
 // Source is the passive scraping agent
 type Source struct {
 	apiKeys   []string
@@ -38,6 +168,111 @@ type Source struct {
 	errors    int
 	results   int
 	skipped   bool
+	retries   int
+
+	// Mode selects the Quake search DSL clause this Source issues, and in
+	// turn the logical sub-source name it reports via Name(). Defaults to
+	// DomainMode.
+	Mode QueryMode
+}
+
+// fetchPage requests a single page of results and decodes it, classifying
+// network and transient API failures as retryable so RetryWithBackoff can
+// retry them, while authentication and quota errors are returned as-is so
+// the caller aborts immediately.
+func (s *Source) fetchPage(ctx context.Context, session *subscraping.Session, apiKey, query string, currentPage, pageSize int) (*quakeResults, error) {
+	requestBody, err := jsoniter.Marshal(quakeRequest{
+		Query:       query,
+		Start:       (currentPage - 1) * pageSize,
+		Size:        pageSize,
+		IgnoreCache: false,
+		Include: []string{
+			"service.http.host", "service.tls.handshake.cert.subject.cn",
+			"service.tls.handshake.cert.extensions.subject_alt_name.dns_names", "domain", "hostname",
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := session.Post(ctx, "https://quake.360.net/api/v3/search/quake_service", "", map[string]string{
+		"Content-Type": "application/json", "X-QuakeToken": apiKey,
+	}, bytes.NewReader(requestBody))
+	if err != nil {
+		session.DiscardHTTPResponse(resp)
+		return nil, &subscraping.RetryableError{Err: err}
+	}
+	defer resp.Body.Close()
+
+	var response quakeResults
+	if err := jsoniter.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, &subscraping.RetryableError{Err: err}
+	}
+
+	if response.Code != 0 {
+		apiErr := fmt.Errorf("%s", response.Message)
+		if isFatalQuakeMessage(response.Message) {
+			return nil, apiErr
+		}
+		return nil, &subscraping.RetryableError{Err: apiErr}
+	}
+
+	return &response, nil
+}
+
+// runQuery pages through a Quake query to completion, skipping any
+// subdomain already present in seen, and returns the error that ended
+// pagination, if any.
+func (s *Source) runQuery(ctx context.Context, session *subscraping.Session, apiKey, domain, query string, seen map[string]struct{}, results chan<- subscraping.Result) error {
+	// quake api doc https://quake.360.cn/quake/#/help
+	var pages = 1
+	var pageSize = 100
+	for currentPage := 1; currentPage <= pages; currentPage++ {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		gologger.Debug().Msgf("Querying %s for %s, query:%q currentPage:%d allPage:%d", s.Name(), domain, query, currentPage, pages)
+
+		var response *quakeResults
+		attempts, err := subscraping.RetryWithBackoff(ctx, maxPageRetries, retryBaseDelay, func(attempt int) error {
+			if attempt > 0 {
+				gologger.Debug().Msgf("Retrying %s for %s, query:%q currentPage:%d attempt:%d", s.Name(), domain, query, currentPage, attempt+1)
+			}
+			var fetchErr error
+			response, fetchErr = s.fetchPage(ctx, session, apiKey, query, currentPage, pageSize)
+			return fetchErr
+		})
+		s.retries += attempts - 1
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			results <- subscraping.Result{Source: s.Name(), Type: subscraping.Error, Error: err}
+			s.errors++
+			return err
+		}
+
+		if response.Meta.Pagination.Total > 0 {
+			for _, record := range response.Data {
+				for _, subdomain := range record.subdomains(session.Extractor) {
+					if _, ok := seen[subdomain]; ok {
+						continue
+					}
+					seen[subdomain] = struct{}{}
+					results <- subscraping.Result{Source: s.Name(), Type: subscraping.Subdomain, Value: subdomain}
+					s.results++
+				}
+			}
+			pages = int(response.Meta.Pagination.Total/pageSize) + 1
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(defaultRateLimit):
+		}
+	}
+	return nil
 }
 
 // Run function returns all subdomains found with the service
@@ -45,10 +280,12 @@ func (s *Source) Run(ctx context.Context, domain string, session *subscraping.Se
 	results := make(chan subscraping.Result)
 	s.errors = 0
 	s.results = 0
+	s.retries = 0
 
 	go func() {
 		defer func(startTime time.Time) {
 			s.timeTaken = time.Since(startTime)
+			gologger.Debug().Msgf("%s made %d retried request(s) for %s", s.Name(), s.retries, domain)
 			close(results)
 		}(time.Now())
 
@@ -58,56 +295,9 @@ func (s *Source) Run(ctx context.Context, domain string, session *subscraping.Se
 			return
 		}
 
-		// quake api doc https://quake.360.cn/quake/#/help
-		var pages = 1
-		var pageSize = 100
-		for currentPage := 1; currentPage <= pages; currentPage++ {
-			gologger.Debug().Msgf("Querying %s for %s, currentPage:%d allPage:%d", s.Name(), domain, currentPage, pages)
-			var requestBody = []byte(fmt.Sprintf(`{"query":"domain: %s", "start":%d, "size":%d,"ignore_cache": false,
-"include": ["service.http.host"]}`,
-				domain, (currentPage-1)*pageSize, pageSize))
-			resp, err := session.Post(ctx, "https://quake.360.net/api/v3/search/quake_service", "", map[string]string{
-				"Content-Type": "application/json", "X-QuakeToken": randomApiKey,
-			}, bytes.NewReader(requestBody))
-			if err != nil {
-				results <- subscraping.Result{Source: s.Name(), Type: subscraping.Error, Error: err}
-				s.errors++
-				session.DiscardHTTPResponse(resp)
-				return
-			}
-
-			var response quakeResults
-			err = jsoniter.NewDecoder(resp.Body).Decode(&response)
-			if err != nil {
-				results <- subscraping.Result{Source: s.Name(), Type: subscraping.Error, Error: err}
-				s.errors++
-				resp.Body.Close()
-				return
-			}
-			resp.Body.Close()
-
-			if response.Code != 0 {
-				results <- subscraping.Result{
-					Source: s.Name(), Type: subscraping.Error, Error: fmt.Errorf("%s", response.Message),
-				}
-				s.errors++
-				return
-			}
-
-			if response.Meta.Pagination.Total > 0 {
-				for _, quakeDomain := range response.Data {
-					subdomain := quakeDomain.Service.HTTP.Host
-					if strings.ContainsAny(subdomain, "暂无权限") {
-						subdomain = ""
-					}
-					results <- subscraping.Result{Source: s.Name(), Type: subscraping.Subdomain, Value: subdomain}
-					s.results++
-				}
-				pages = int(response.Meta.Pagination.Total/pageSize) + 1
-			}
-			time.Sleep(3 * time.Second)
-
-		}
+		query := fmt.Sprintf(s.Mode.queryTemplate(), domain)
+		seen := make(map[string]struct{})
+		s.runQuery(ctx, session, randomApiKey, domain, query, seen, results)
 	}()
 
 	return results
@@ -115,11 +305,14 @@ func (s *Source) Run(ctx context.Context, domain string, session *subscraping.Se
 
 // Name returns the name of the source
 func (s *Source) Name() string {
-	return "quake"
+	return s.Mode.name()
 }
 
+// IsDefault reports whether this Source runs as part of the default
+// enumeration. Only the domain-query mode does; quake-cert and quake-dns
+// spend separate paid credits and must be selected explicitly.
 func (s *Source) IsDefault() bool {
-	return true
+	return s.Mode == DomainMode
 }
 
 func (s *Source) HasRecursiveSupport() bool {
@@ -138,6 +331,7 @@ func (s *Source) Statistics() subscraping.Statistics {
 	return subscraping.Statistics{
 		Errors:    s.errors,
 		Results:   s.results,
+		Retries:   s.retries,
 		TimeTaken: s.timeTaken,
 		Skipped:   s.skipped,
 	}
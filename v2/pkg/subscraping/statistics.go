@@ -0,0 +1,12 @@
+package subscraping
+
+import "time"
+
+// Statistics contains statistics for a passive source run.
+type Statistics struct {
+	TimeTaken time.Duration
+	Errors    int
+	Results   int
+	Retries   int
+	Skipped   bool
+}